@@ -0,0 +1,197 @@
+// Package gitea implements hosting.Provider against the Gitea API, using
+// code.gitea.io/sdk/gitea. Gitea has no hosted github.com-equivalent, so
+// baseURL is always required.
+package gitea
+
+import (
+	"context"
+	"time"
+
+	giteaapi "code.gitea.io/sdk/gitea"
+	"github.com/benesch/prmaster/hosting"
+)
+
+const pageSize = 50
+
+// Provider is a hosting.Provider backed by a Gitea instance's API.
+type Provider struct {
+	Client *giteaapi.Client
+}
+
+// New constructs a Provider authenticated with token against the Gitea
+// instance at baseURL.
+func New(ctx context.Context, token, baseURL string) (*Provider, error) {
+	client, err := giteaapi.NewClient(baseURL, giteaapi.SetToken(token), giteaapi.SetContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return &Provider{Client: client}, nil
+}
+
+// CurrentUser implements hosting.Provider.
+func (p *Provider) CurrentUser(ctx context.Context) (string, error) {
+	p.Client.SetContext(ctx)
+	user, _, err := p.Client.GetMyUserInfo()
+	if err != nil {
+		return "", err
+	}
+	return user.UserName, nil
+}
+
+// ListBranches implements hosting.Provider.
+func (p *Provider) ListBranches(ctx context.Context, owner, repo string) ([]hosting.Branch, error) {
+	p.Client.SetContext(ctx)
+	var branches []hosting.Branch
+	for page := 1; ; page++ {
+		giteaBranches, _, err := p.Client.ListRepoBranches(owner, repo, giteaapi.ListRepoBranchesOptions{
+			ListOptions: giteaapi.ListOptions{Page: page, PageSize: pageSize},
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range giteaBranches {
+			branches = append(branches, hosting.Branch{
+				Name:   b.Name,
+				Commit: hosting.Commit{SHA: b.Commit.ID, CommitDate: b.Commit.Timestamp},
+			})
+		}
+		if len(giteaBranches) < pageSize {
+			break
+		}
+	}
+	return branches, nil
+}
+
+// SearchOpenPRs implements hosting.Provider.
+func (p *Provider) SearchOpenPRs(ctx context.Context, owner, repo, author string) ([]hosting.PR, error) {
+	p.Client.SetContext(ctx)
+	var prs []hosting.PR
+	for page := 1; ; page++ {
+		issues, _, err := p.Client.ListRepoIssues(owner, repo, giteaapi.ListIssueOption{
+			ListOptions: giteaapi.ListOptions{Page: page, PageSize: pageSize},
+			State:       giteaapi.StateOpen,
+			Type:        giteaapi.IssueTypePull,
+			CreatedBy:   author,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, issue := range issues {
+			pr, err := p.GetPR(ctx, owner, repo, int(issue.Index))
+			if err != nil {
+				return nil, err
+			}
+			prs = append(prs, pr)
+		}
+		if len(issues) < pageSize {
+			break
+		}
+	}
+	return prs, nil
+}
+
+// GetPR implements hosting.Provider.
+func (p *Provider) GetPR(ctx context.Context, owner, repo string, number int) (hosting.PR, error) {
+	p.Client.SetContext(ctx)
+	pr, _, err := p.Client.GetPullRequest(owner, repo, int64(number))
+	if err != nil {
+		return hosting.PR{}, err
+	}
+	return newPR(pr), nil
+}
+
+// ListPRCommits implements hosting.Provider.
+func (p *Provider) ListPRCommits(ctx context.Context, owner, repo string, number int) ([]hosting.Commit, error) {
+	p.Client.SetContext(ctx)
+	var commits []hosting.Commit
+	for page := 1; ; page++ {
+		giteaCommits, _, err := p.Client.ListPullRequestCommits(owner, repo, int64(number), giteaapi.ListPullRequestCommitsOptions{
+			ListOptions: giteaapi.ListOptions{Page: page, PageSize: pageSize},
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range giteaCommits {
+			commits = append(commits, hosting.Commit{SHA: c.SHA, CommitDate: commitDate(c)})
+		}
+		if len(giteaCommits) < pageSize {
+			break
+		}
+	}
+	return commits, nil
+}
+
+// FindPRForBranch implements hosting.Provider.
+//
+// The Gitea API has no way to filter PRs by source branch, so this fetches
+// every open-or-closed PR and scans for a head matching both branch and
+// headOwner -- branch alone isn't enough to avoid attaching the wrong fork's
+// PR when two forks share a branch-naming convention. This is the one place
+// the Gitea provider pays for Gitea's API being the least expressive of the
+// three backends.
+func (p *Provider) FindPRForBranch(ctx context.Context, owner, repo, headOwner, branch string) (*hosting.PR, error) {
+	p.Client.SetContext(ctx)
+	var found *giteaapi.PullRequest
+	for page := 1; ; page++ {
+		prs, _, err := p.Client.ListRepoPullRequests(owner, repo, giteaapi.ListPullRequestsOptions{
+			ListOptions: giteaapi.ListOptions{Page: page, PageSize: pageSize},
+			State:       giteaapi.StateAll,
+			Sort:        "recentupdate",
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, pr := range prs {
+			if pr.Head == nil || pr.Head.Ref != branch {
+				continue
+			}
+			if pr.Head.Repository == nil || pr.Head.Repository.Owner.UserName != headOwner {
+				continue
+			}
+			found = pr
+			break
+		}
+		if found != nil || len(prs) < pageSize {
+			break
+		}
+	}
+	if found == nil {
+		return nil, nil
+	}
+	pr := newPR(found)
+	return &pr, nil
+}
+
+func newPR(pr *giteaapi.PullRequest) hosting.PR {
+	var headRef, headSHA string
+	if pr.Head != nil {
+		headRef, headSHA = pr.Head.Ref, pr.Head.Sha
+	}
+	var mergeCommit string
+	if pr.MergedCommitID != nil {
+		mergeCommit = *pr.MergedCommitID
+	}
+	var createdAt time.Time
+	if pr.Created != nil {
+		createdAt = *pr.Created
+	}
+	return hosting.PR{
+		Number:      int(pr.Index),
+		Title:       pr.Title,
+		HeadRef:     headRef,
+		HeadSHA:     headSHA,
+		State:       string(pr.State),
+		Merged:      pr.HasMerged,
+		MergeCommit: mergeCommit,
+		CreatedAt:   createdAt,
+		URL:         pr.HTMLURL,
+	}
+}
+
+func commitDate(c *giteaapi.Commit) time.Time {
+	if c.RepoCommit == nil || c.RepoCommit.Committer == nil {
+		return time.Time{}
+	}
+	t, _ := time.Parse(time.RFC3339, c.RepoCommit.Committer.Date)
+	return t
+}
@@ -0,0 +1,175 @@
+// Package github implements hosting.Provider against the GitHub REST API,
+// using google/go-github. It supports both github.com and GitHub
+// Enterprise, via baseURL.
+package github
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/benesch/prmaster/hosting"
+	ghapi "github.com/google/go-github/github"
+	"golang.org/x/oauth2"
+	"golang.org/x/sync/errgroup"
+)
+
+// Provider is a hosting.Provider backed by the GitHub REST API. Client is
+// exported so that callers needing GitHub-specific functionality --
+// currently just the CI and review status shown by `prmaster list` --
+// can fall back to the underlying go-github client.
+type Provider struct {
+	Client *ghapi.Client
+}
+
+// New constructs a Provider. token, if non-empty, authenticates all
+// requests. baseURL, if non-empty, points the client at a GitHub
+// Enterprise instance instead of github.com.
+func New(ctx context.Context, token, baseURL string) (*Provider, error) {
+	var httpClient *http.Client
+	if token != "" {
+		httpClient = oauth2.NewClient(ctx, oauth2.StaticTokenSource(
+			&oauth2.Token{AccessToken: token}))
+	}
+	if baseURL == "" {
+		return &Provider{Client: ghapi.NewClient(httpClient)}, nil
+	}
+	client, err := ghapi.NewEnterpriseClient(baseURL, baseURL, httpClient)
+	if err != nil {
+		return nil, err
+	}
+	return &Provider{Client: client}, nil
+}
+
+// CurrentUser implements hosting.Provider.
+func (p *Provider) CurrentUser(ctx context.Context) (string, error) {
+	user, _, err := p.Client.Users.Get(ctx, "")
+	if err != nil {
+		return "", err
+	}
+	return user.GetLogin(), nil
+}
+
+// ListBranches implements hosting.Provider.
+func (p *Provider) ListBranches(ctx context.Context, owner, repo string) ([]hosting.Branch, error) {
+	var branches []hosting.Branch
+	for page := 1; page != 0; {
+		ghBranches, res, err := p.Client.Repositories.ListBranches(
+			ctx, owner, repo, &ghapi.ListOptions{PerPage: 100, Page: page})
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range ghBranches {
+			branches = append(branches, hosting.Branch{
+				Name:   b.GetName(),
+				Commit: newCommit(b.GetCommit()),
+			})
+		}
+		page = res.NextPage
+	}
+	return branches, nil
+}
+
+// SearchOpenPRs implements hosting.Provider.
+func (p *Provider) SearchOpenPRs(ctx context.Context, owner, repo, author string) ([]hosting.PR, error) {
+	opts := &ghapi.SearchOptions{Sort: "created", ListOptions: ghapi.ListOptions{PerPage: 100}}
+	query := fmt.Sprintf("type:pr is:open repo:%s/%s author:%s", owner, repo, author)
+	var issues []ghapi.Issue
+	for {
+		res, resp, err := p.Client.Search.Issues(ctx, query, opts)
+		if err != nil {
+			return nil, err
+		}
+		issues = append(issues, res.Issues...)
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	prs := make([]hosting.PR, len(issues))
+	var g errgroup.Group
+	// Limit concurrency. The GitHub API doesn't like too many concurrent
+	// requests; see the comment on the same pattern in ListBranches' caller.
+	sem := make(chan struct{}, 32)
+	for i, issue := range issues {
+		i, number := i, issue.GetNumber()
+		g.Go(func() error {
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			pr, err := p.GetPR(ctx, owner, repo, number)
+			if err != nil {
+				return err
+			}
+			prs[i] = pr
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	return prs, nil
+}
+
+// GetPR implements hosting.Provider.
+func (p *Provider) GetPR(ctx context.Context, owner, repo string, number int) (hosting.PR, error) {
+	pr, _, err := p.Client.PullRequests.Get(ctx, owner, repo, number)
+	if err != nil {
+		return hosting.PR{}, err
+	}
+	return newPR(pr), nil
+}
+
+// ListPRCommits implements hosting.Provider.
+func (p *Provider) ListPRCommits(ctx context.Context, owner, repo string, number int) ([]hosting.Commit, error) {
+	commits, _, err := p.Client.PullRequests.ListCommits(ctx, owner, repo, number, nil)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]hosting.Commit, len(commits))
+	for i, c := range commits {
+		out[i] = newCommit(c)
+	}
+	return out, nil
+}
+
+// FindPRForBranch implements hosting.Provider.
+func (p *Provider) FindPRForBranch(ctx context.Context, owner, repo, headOwner, branch string) (*hosting.PR, error) {
+	prOpts := &ghapi.PullRequestListOptions{
+		State:     "all",
+		Head:      fmt.Sprintf("%s:%s", headOwner, branch),
+		Sort:      "updated",
+		Direction: "desc",
+	}
+	prs, _, err := p.Client.PullRequests.List(ctx, owner, repo, prOpts)
+	if err != nil {
+		return nil, err
+	}
+	if len(prs) == 0 {
+		return nil, nil
+	}
+	// PRs are sorted so that the most recently updated PR is first.
+	pr := newPR(prs[0])
+	return &pr, nil
+}
+
+func newCommit(c *ghapi.RepositoryCommit) hosting.Commit {
+	return hosting.Commit{
+		SHA:        c.GetSHA(),
+		CommitDate: c.GetCommit().GetCommitter().GetDate(),
+	}
+}
+
+func newPR(pr *ghapi.PullRequest) hosting.PR {
+	return hosting.PR{
+		Number:      pr.GetNumber(),
+		Title:       pr.GetTitle(),
+		HeadRef:     pr.GetHead().GetRef(),
+		HeadSHA:     pr.GetHead().GetSHA(),
+		State:       pr.GetState(),
+		Merged:      pr.GetMerged(),
+		MergeCommit: pr.GetMergeCommitSHA(),
+		CreatedAt:   pr.GetCreatedAt(),
+		URL:         pr.GetHTMLURL(),
+	}
+}
@@ -0,0 +1,201 @@
+// Package gitlab implements hosting.Provider against the GitLab REST API,
+// using xanzy/go-gitlab. It supports both gitlab.com and self-hosted GitLab
+// instances, via baseURL.
+package gitlab
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/benesch/prmaster/hosting"
+	glapi "github.com/xanzy/go-gitlab"
+)
+
+// Provider is a hosting.Provider backed by the GitLab REST API. GitLab
+// calls pull requests "merge requests"; Provider maps between the two
+// throughout.
+type Provider struct {
+	Client *glapi.Client
+}
+
+// New constructs a Provider authenticated with the personal access token.
+// baseURL, if non-empty, points the client at a self-hosted GitLab instance
+// instead of gitlab.com.
+func New(ctx context.Context, token, baseURL string) (*Provider, error) {
+	var opts []glapi.ClientOptionFunc
+	if baseURL != "" {
+		opts = append(opts, glapi.WithBaseURL(baseURL))
+	}
+	client, err := glapi.NewClient(token, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &Provider{Client: client}, nil
+}
+
+// CurrentUser implements hosting.Provider.
+func (p *Provider) CurrentUser(ctx context.Context) (string, error) {
+	user, _, err := p.Client.Users.CurrentUser(glapi.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	return user.Username, nil
+}
+
+// ListBranches implements hosting.Provider.
+func (p *Provider) ListBranches(ctx context.Context, owner, repo string) ([]hosting.Branch, error) {
+	var branches []hosting.Branch
+	opt := &glapi.ListBranchesOptions{ListOptions: glapi.ListOptions{PerPage: 100}}
+	for {
+		glBranches, res, err := p.Client.Branches.ListBranches(project(owner, repo), opt, glapi.WithContext(ctx))
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range glBranches {
+			branches = append(branches, hosting.Branch{
+				Name:   b.Name,
+				Commit: newCommit(b.Commit),
+			})
+		}
+		if res.NextPage == 0 {
+			break
+		}
+		opt.Page = res.NextPage
+	}
+	return branches, nil
+}
+
+// SearchOpenPRs implements hosting.Provider.
+func (p *Provider) SearchOpenPRs(ctx context.Context, owner, repo, author string) ([]hosting.PR, error) {
+	opt := &glapi.ListProjectMergeRequestsOptions{
+		ListOptions:    glapi.ListOptions{PerPage: 100},
+		State:          glapi.String("opened"),
+		AuthorUsername: glapi.String(author),
+		OrderBy:        glapi.String("created_at"),
+	}
+	var prs []hosting.PR
+	for {
+		mrs, res, err := p.Client.MergeRequests.ListProjectMergeRequests(project(owner, repo), opt, glapi.WithContext(ctx))
+		if err != nil {
+			return nil, err
+		}
+		for _, mr := range mrs {
+			prs = append(prs, newPR(mr))
+		}
+		if res.NextPage == 0 {
+			break
+		}
+		opt.Page = res.NextPage
+	}
+	return prs, nil
+}
+
+// GetPR implements hosting.Provider.
+func (p *Provider) GetPR(ctx context.Context, owner, repo string, number int) (hosting.PR, error) {
+	mr, _, err := p.Client.MergeRequests.GetMergeRequest(project(owner, repo), number, nil, glapi.WithContext(ctx))
+	if err != nil {
+		return hosting.PR{}, err
+	}
+	return newPR(mr), nil
+}
+
+// ListPRCommits implements hosting.Provider.
+func (p *Provider) ListPRCommits(ctx context.Context, owner, repo string, number int) ([]hosting.Commit, error) {
+	commits, _, err := p.Client.MergeRequests.GetMergeRequestCommits(project(owner, repo), number, nil, glapi.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	// GitLab lists a merge request's commits newest first; reverse them into
+	// the oldest-first order hosting.Provider documents.
+	out := make([]hosting.Commit, len(commits))
+	for i, c := range commits {
+		out[len(commits)-1-i] = hosting.Commit{SHA: c.ID, CommitDate: commitDate(c)}
+	}
+	return out, nil
+}
+
+// FindPRForBranch implements hosting.Provider.
+func (p *Provider) FindPRForBranch(ctx context.Context, owner, repo, headOwner, branch string) (*hosting.PR, error) {
+	opt := &glapi.ListProjectMergeRequestsOptions{
+		SourceBranch: glapi.String(branch),
+		State:        glapi.String("all"),
+		OrderBy:      glapi.String("updated_at"),
+		Sort:         glapi.String("desc"),
+	}
+	mrs, _, err := p.Client.MergeRequests.ListProjectMergeRequests(project(owner, repo), opt, glapi.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	// MRs are sorted so that the most recently updated one is first, but
+	// SourceBranch alone doesn't distinguish forks that share a branch-naming
+	// convention, so take the first whose source project's namespace actually
+	// matches headOwner.
+	for _, mr := range mrs {
+		ns, err := p.sourceNamespace(ctx, mr)
+		if err != nil {
+			return nil, err
+		}
+		if ns == headOwner {
+			pr := newPR(mr)
+			return &pr, nil
+		}
+	}
+	return nil, nil
+}
+
+// sourceNamespace resolves a merge request's source project ID into its
+// namespace (e.g. "group/subgroup"), since the merge request payload itself
+// only carries the source project's numeric ID, not the owner string
+// FindPRForBranch needs to filter on.
+func (p *Provider) sourceNamespace(ctx context.Context, mr *glapi.MergeRequest) (string, error) {
+	proj, _, err := p.Client.Projects.GetProject(mr.SourceProjectID, nil, glapi.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	return proj.Namespace.FullPath, nil
+}
+
+// newPR converts a GitLab merge request into a hosting.PR, collapsing
+// GitLab's three-state "opened"/"closed"/"merged" down to the "open"/"closed"
+// that hosting.PR.State documents -- Merged already carries whether a closed
+// MR was merged, so the collapse doesn't lose any information.
+func newPR(mr *glapi.MergeRequest) hosting.PR {
+	state := "closed"
+	if mr.State == "opened" {
+		state = "open"
+	}
+	return hosting.PR{
+		Number:      mr.IID,
+		Title:       mr.Title,
+		HeadRef:     mr.SourceBranch,
+		HeadSHA:     mr.SHA,
+		State:       state,
+		Merged:      mr.State == "merged",
+		MergeCommit: mr.MergeCommitSHA,
+		CreatedAt:   timeValue(mr.CreatedAt),
+		URL:         mr.WebURL,
+	}
+}
+
+func newCommit(c *glapi.Commit) hosting.Commit {
+	return hosting.Commit{SHA: c.ID, CommitDate: commitDate(c)}
+}
+
+func commitDate(c *glapi.Commit) time.Time {
+	return timeValue(c.CommittedDate)
+}
+
+// timeValue dereferences a *time.Time, returning the zero time for nil.
+func timeValue(t *time.Time) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	return *t
+}
+
+// project formats owner/repo as the "namespace/project" identifier GitLab's
+// API expects in place of a numeric project ID.
+func project(owner, repo string) string {
+	return fmt.Sprintf("%s/%s", owner, repo)
+}
@@ -0,0 +1,62 @@
+// Package hosting abstracts over the code-hosting backends prmaster can
+// talk to -- GitHub, GitLab, and Gitea -- so that the rest of prmaster can
+// be written once against a single Provider interface instead of branching
+// on which forge a repository happens to be hosted on.
+package hosting
+
+import (
+	"context"
+	"time"
+)
+
+// Commit is a single commit, reduced to the fields prmaster needs
+// regardless of which forge reported it.
+type Commit struct {
+	SHA        string
+	CommitDate time.Time
+}
+
+// Branch is a single branch of a repository, as reported by
+// Provider.ListBranches.
+type Branch struct {
+	Name   string
+	Commit Commit
+}
+
+// PR is a pull request, or the local equivalent (a GitLab merge request, a
+// Gitea pull request), reduced to the fields prmaster needs regardless of
+// which forge hosts it.
+type PR struct {
+	Number      int
+	Title       string
+	HeadRef     string
+	HeadSHA     string
+	State       string // "open" or "closed"
+	Merged      bool
+	MergeCommit string
+	CreatedAt   time.Time
+	URL         string
+}
+
+// Provider is a code-hosting backend. prmaster's branch and PR bookkeeping
+// is written entirely in terms of this interface so that `sync`, `list`,
+// and `watch` behave the same way regardless of whether the upstream
+// repository lives on github.com, a self-hosted GitLab, or a self-hosted
+// Gitea instance.
+type Provider interface {
+	// CurrentUser returns the login of the authenticated user.
+	CurrentUser(ctx context.Context) (string, error)
+	// ListBranches lists all branches of owner/repo.
+	ListBranches(ctx context.Context, owner, repo string) ([]Branch, error)
+	// SearchOpenPRs returns every open PR against owner/repo authored by
+	// author.
+	SearchOpenPRs(ctx context.Context, owner, repo, author string) ([]PR, error)
+	// GetPR returns a single PR by number.
+	GetPR(ctx context.Context, owner, repo string, number int) (PR, error)
+	// ListPRCommits lists the commits on a PR, oldest first.
+	ListPRCommits(ctx context.Context, owner, repo string, number int) ([]Commit, error)
+	// FindPRForBranch returns the most recently-updated PR, in any state,
+	// opened from headOwner:branch against owner/repo, or nil if there is
+	// no such PR.
+	FindPRForBranch(ctx context.Context, owner, repo, headOwner, branch string) (*PR, error)
+}
@@ -1,16 +1,25 @@
 package main
 
 import (
+	"bufio"
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"regexp"
 	"strings"
 	"time"
 
-	"github.com/google/go-github/github"
+	"github.com/benesch/prmaster/hosting"
+	giteahosting "github.com/benesch/prmaster/hosting/gitea"
+	githubhosting "github.com/benesch/prmaster/hosting/github"
+	gitlabhosting "github.com/benesch/prmaster/hosting/gitlab"
+	ghapi "github.com/google/go-github/github"
 	color "github.com/logrusorgru/aurora"
 	"github.com/pkg/errors"
 	"github.com/vbauerster/mpb"
@@ -20,18 +29,19 @@ import (
 )
 
 const usage = `usage: prmaster sync [-n]
-          or: prmaster list`
+          or: prmaster list [-v]
+          or: prmaster watch [-interval DURATION] [-auto-prune]
+          or: prmaster login [-pat]`
 
 func main() {
 	if err := run(context.Background()); err != nil {
 		fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
 
 		cause := errors.Cause(err)
-		if _, ok := cause.(*github.RateLimitError); ok {
+		if _, ok := cause.(*ghapi.RateLimitError); ok {
 			fmt.Fprintln(os.Stderr, `hint: unauthenticated GitHub requests are subject to a very strict rate
-limit. Please configure prmaster with a personal access token:
-    $ git config --global prmaster.githubToken TOKEN
-For help creating a personal access token, see https://goo.gl/Ep2E6x.`)
+limit. Authenticate prmaster by running:
+    $ prmaster login`)
 		} else if err, ok := cause.(hintedErr); ok {
 			fmt.Fprintf(os.Stderr, "hint: %s\n", err.hint)
 		}
@@ -45,6 +55,10 @@ func run(ctx context.Context) error {
 		return errors.New(usage)
 	}
 
+	if os.Args[1] == "login" {
+		return runLogin(ctx)
+	}
+
 	c, err := loadConfig(ctx)
 	if err != nil {
 		return err
@@ -55,44 +69,274 @@ func run(ctx context.Context) error {
 		return runList(ctx, c)
 	case "sync":
 		return runSync(ctx, c)
+	case "watch":
+		return runWatch(ctx, c)
 	default:
 		return fmt.Errorf("unknown command %s", cmd)
 	}
 }
 
 func runList(ctx context.Context, c config) error {
-	if len(os.Args) != 2 {
+	var verbose bool
+	flagSet := flag.NewFlagSet("list", flag.ContinueOnError)
+	flagSet.BoolVar(&verbose, "v", false, "show a breakdown of failing checks for each PR")
+	if err := flagSet.Parse(os.Args[2:]); err != nil {
+		return err
+	} else if flagSet.NArg() != 0 {
 		return errors.New(usage)
 	}
-	opts := &github.SearchOptions{
-		Sort: "created",
-	}
-	query := fmt.Sprintf("type:pr is:open repo:%s/%s author:%s",
-		c.upstreamUsername, c.repo, c.username)
-	res, _, err := c.ghClient.Search.Issues(ctx, query, opts)
+
+	prs, err := c.provider.SearchOpenPRs(ctx, c.upstreamUsername, c.repo, c.username)
 	if err != nil {
 		return err
 	}
-	for _, issue := range res.Issues {
-		pr, _, err := c.ghClient.PullRequests.Get(ctx, c.upstreamUsername, c.repo, *issue.Number)
-		if err != nil {
+
+	statuses := make([]prStatus, len(prs))
+	var g errgroup.Group
+	// Limit concurrency for the same reason as loadBranches: too many
+	// concurrent requests can trip GitHub's secondary rate limit even for
+	// authenticated users.
+	sem := make(chan struct{}, 32)
+	for i := range prs {
+		i := i
+		g.Go(func() error {
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			var err error
+			statuses[i], err = loadPRStatus(ctx, c, &prs[i])
 			return err
-		}
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return err
+	}
+
+	for i, pr := range prs {
 		dateColor := color.Green
-		if time.Since(*pr.CreatedAt) > 30*24*time.Hour {
+		if time.Since(pr.CreatedAt) > 30*24*time.Hour {
 			dateColor = color.Red
-		} else if time.Since(*pr.CreatedAt) > 7*24*time.Hour {
+		} else if time.Since(pr.CreatedAt) > 7*24*time.Hour {
 			dateColor = color.Brown
 		}
 		fmt.Printf(
-			"%s\n    Branch %s. Opened %s.\n    https://github.com/%s/%s/pull/%d\n",
-			color.Bold(*pr.Title), color.Cyan(*pr.Head.Ref),
-			dateColor(pr.CreatedAt.Format("2006-01-02")),
-			c.upstreamUsername, c.repo, *pr.Number)
+			"%s %s\n    Branch %s. Opened %s.\n    %s\n",
+			statuses[i].symbol(), color.Bold(pr.Title), color.Cyan(pr.HeadRef),
+			dateColor(pr.CreatedAt.Format("2006-01-02")), pr.URL)
+		if verbose {
+			statuses[i].printBreakdown("    ")
+		}
 	}
 	return nil
 }
 
+// ciState summarizes the combined state of a PR's CI, merging GitHub's
+// legacy commit-status API with the newer Checks API the way `gh
+// ci-status` does.
+type ciState int
+
+const (
+	ciUnknown ciState = iota
+	ciPending
+	ciSuccess
+	ciFailure
+)
+
+// reviewState summarizes a PR's reviews, collapsed down to the single most
+// significant outcome across all reviewers.
+type reviewState int
+
+const (
+	reviewNone reviewState = iota
+	reviewPending
+	reviewApproved
+	reviewChangesRequested
+)
+
+func (s reviewState) String() string {
+	switch s {
+	case reviewApproved:
+		return "approved"
+	case reviewChangesRequested:
+		return "changes requested"
+	case reviewPending:
+		return "review pending"
+	default:
+		return "no review"
+	}
+}
+
+// failingCheck identifies a single failing status or check run, so that -v
+// can point the user straight at its logs.
+type failingCheck struct {
+	name string
+	url  string
+}
+
+// prStatus is the combined CI and review state of a PR, as shown by the
+// colored prefix in runList and expanded by its -v flag.
+type prStatus struct {
+	ci       ciState
+	review   reviewState
+	failures []failingCheck
+}
+
+// symbol renders a prStatus as the single colored character shown next to
+// each PR, combining CI and review state the way `gh ci-status` combines
+// success/error/failure/pending into one exit code.
+func (s prStatus) symbol() color.Value {
+	switch {
+	case s.ci == ciFailure || s.review == reviewChangesRequested:
+		return color.Red("✗")
+	case s.ci == ciPending || s.review == reviewPending:
+		return color.Brown("•")
+	case s.ci == ciSuccess && s.review == reviewApproved:
+		return color.Green("✓")
+	default:
+		return color.Gray(12, "?")
+	}
+}
+
+// printBreakdown expands a prStatus into a multi-line listing of why it
+// isn't a plain green check: the review state, plus the name and URL of
+// every failing check, so the user can jump straight to the logs.
+func (s prStatus) printBreakdown(indent string) {
+	fmt.Printf("%sReview: %s\n", indent, s.review)
+	for _, f := range s.failures {
+		if f.url == "" {
+			fmt.Printf("%sFailing: %s\n", indent, f.name)
+		} else {
+			fmt.Printf("%sFailing: %s (%s)\n", indent, f.name, f.url)
+		}
+	}
+}
+
+// loadPRStatus fetches and combines a PR's commit statuses, check runs, and
+// reviews into a single prStatus. This enrichment is currently GitHub-only:
+// GitLab pipelines and Gitea's commit statuses would need their own
+// provider-specific plumbing, so prStatus stays empty (rendered as a grey
+// "?") for those backends.
+func loadPRStatus(ctx context.Context, c config, pr *hosting.PR) (prStatus, error) {
+	var s prStatus
+	ghp, ok := c.provider.(*githubhosting.Provider)
+	if !ok {
+		return s, nil
+	}
+	client := ghp.Client
+	sha := pr.HeadSHA
+
+	// hasSignal tracks whether we've seen any commit status or check run at
+	// all. A repo that only uses GitHub Actions reports a combined status
+	// with TotalCount 0 -- that's "no legacy statuses", not "pending" -- so
+	// treating it as ciPending by default would leave every fully green,
+	// Actions-only PR stuck showing pending forever.
+	var hasSignal, anyFailure, anyPending bool
+
+	for page := 1; page != 0; {
+		combined, res, err := client.Repositories.GetCombinedStatus(
+			ctx, c.upstreamUsername, c.repo, sha, &ghapi.ListOptions{PerPage: 100, Page: page})
+		if err != nil {
+			return s, err
+		}
+		if combined.GetTotalCount() > 0 {
+			hasSignal = true
+			switch combined.GetState() {
+			case "failure", "error":
+				anyFailure = true
+			case "pending":
+				anyPending = true
+			}
+		}
+		for _, st := range combined.Statuses {
+			if st.GetState() == "failure" || st.GetState() == "error" {
+				s.failures = append(s.failures, failingCheck{name: st.GetContext(), url: st.GetTargetURL()})
+			}
+		}
+		page = res.NextPage
+	}
+
+	for page := 1; page != 0; {
+		checks, res, err := client.Checks.ListCheckRunsForRef(
+			ctx, c.upstreamUsername, c.repo, sha, &ghapi.ListCheckRunsOptions{ListOptions: ghapi.ListOptions{PerPage: 100, Page: page}})
+		if err != nil {
+			return s, err
+		}
+		for _, run := range checks.CheckRuns {
+			hasSignal = true
+			if run.GetStatus() != "completed" {
+				anyPending = true
+				continue
+			}
+			switch run.GetConclusion() {
+			case "success", "neutral", "skipped", "":
+			default:
+				anyFailure = true
+				s.failures = append(s.failures, failingCheck{name: run.GetName(), url: run.GetHTMLURL()})
+			}
+		}
+		page = res.NextPage
+	}
+
+	switch {
+	case !hasSignal, anyPending && !anyFailure:
+		s.ci = ciPending
+	case anyFailure:
+		s.ci = ciFailure
+	default:
+		s.ci = ciSuccess
+	}
+
+	reviews, _, err := client.PullRequests.ListReviews(ctx, c.upstreamUsername, c.repo, pr.Number, nil)
+	if err != nil {
+		return s, err
+	}
+	s.review = collapseReviews(reviews)
+
+	return s, nil
+}
+
+// collapseReviews reduces a PR's review history down to a single state,
+// keeping only the most recent review submitted by each reviewer. It mirrors
+// how GitHub itself derives a PR's effective review state: a COMMENTED
+// review is informational and doesn't replace a reviewer's prior verdict,
+// while DISMISSED clears it, leaving the reviewer back at no verdict.
+func collapseReviews(reviews []*ghapi.PullRequestReview) reviewState {
+	if len(reviews) == 0 {
+		return reviewNone
+	}
+	// "" means the reviewer has weighed in but left no verdict standing --
+	// either a COMMENTED with no prior verdict to preserve, or an
+	// APPROVED/CHANGES_REQUESTED that was since DISMISSED -- which is
+	// distinct from never having reviewed at all, so it can't be represented
+	// by the key being absent from latest.
+	latest := make(map[string]string)
+	for _, r := range reviews {
+		user := r.GetUser().GetLogin()
+		switch r.GetState() {
+		case "COMMENTED":
+			if _, ok := latest[user]; !ok {
+				latest[user] = ""
+			}
+		case "DISMISSED":
+			latest[user] = ""
+		default:
+			latest[user] = r.GetState()
+		}
+	}
+	approved := false
+	for _, state := range latest {
+		if state == "CHANGES_REQUESTED" {
+			return reviewChangesRequested
+		}
+		if state == "APPROVED" {
+			approved = true
+		}
+	}
+	if approved {
+		return reviewApproved
+	}
+	return reviewPending
+}
+
 func runSync(ctx context.Context, c config) error {
 	var dryRun bool
 	flagSet := flag.NewFlagSet("sync", flag.ContinueOnError)
@@ -118,6 +362,11 @@ func runSync(ctx context.Context, c config) error {
 		return err
 	}
 
+	upstreamDefaultRef, err := defaultBranchRef(c.upstreamRemote)
+	if err != nil {
+		fmt.Printf("Warning: %s; skipping patch-id based branch detection.\n", err)
+	}
+
 	var localDeletes, remoteDeletes []*branch
 	for i := range branches {
 		b := &branches[i]
@@ -130,30 +379,40 @@ func runSync(ctx context.Context, c config) error {
 			fmt.Printf("Skipping %s. It's checked out in your current worktree.\n", colorName)
 			continue
 		}
-		if b.pr.commit == nil {
+		if b.pr.Commit == nil {
 			fmt.Printf("Skipping %s. Not associated with any PRs.\n", colorName)
 			continue
 		}
-		if b.pr.GetState() == "open" {
-			fmt.Printf("Skipping %s. PR #%d is still open.\n", colorName, b.pr.GetNumber())
+		if b.pr.State == "open" {
+			fmt.Printf("Skipping %s. PR #%d is still open.\n", colorName, b.pr.Number)
 			continue
 		}
+
+		merged, mergeCommit, err := refreshMergedStatus(ctx, c, b)
+		if err != nil {
+			fmt.Printf("Warning: couldn't refresh PR #%d: %s\n", b.pr.Number, err)
+		}
+
 		if b.remote != nil {
-			if b.remote.sha == b.pr.sha || b.remote.commitDate.Before(b.pr.commitDate) {
+			if ok, reason := branchQualifiesForDeletion(
+				b.remote, b, upstreamDefaultRef, c.remote+"/"+b.name, merged, mergeCommit,
+			); ok {
 				remoteDeletes = append(remoteDeletes, b)
-				fmt.Printf("%s remote %s. PR #%d is closed.\n", colorDelete,
-					colorName, b.pr.GetNumber())
+				fmt.Printf("%s remote %s. %s.\n", colorDelete, colorName, reason)
 			} else {
 				fmt.Printf("Skipping remote %s. Branch commit is newer than #%d.\n",
-					colorName, b.pr.GetNumber())
+					colorName, b.pr.Number)
 			}
 		}
 		if b.local != nil {
-			if b.local.sha == b.pr.sha || b.local.commitDate.Before(b.pr.commitDate) {
+			if ok, reason := branchQualifiesForDeletion(
+				b.local, b, upstreamDefaultRef, b.name, merged, mergeCommit,
+			); ok {
 				localDeletes = append(localDeletes, b)
+				fmt.Printf("%s local %s. %s.\n", colorDelete, colorName, reason)
 			} else {
 				fmt.Printf("Skipping local %s. Branch commit is newer than #%d.\n",
-					colorName, b.pr.GetNumber())
+					colorName, b.pr.Number)
 			}
 		}
 	}
@@ -186,7 +445,7 @@ func runSync(ctx context.Context, c config) error {
 	}
 
 	if noPRBranches := branches.filter(func(b branch) bool {
-		return !b.isRelease() && b.remote != nil && b.pr.commit == nil
+		return !b.isRelease() && b.remote != nil && b.pr.Commit == nil
 	}); len(noPRBranches) > 0 {
 		fmt.Println()
 		fmt.Println("These remote branches do not have open PRs:")
@@ -194,7 +453,7 @@ func runSync(ctx context.Context, c config) error {
 			fmt.Printf("    %s\n", b.name)
 		}
 		fmt.Println()
-		fmt.Printf("    Manage: https://github.com/%s/%s/branches/yours\n", c.username, c.repo)
+		fmt.Printf("    Manage: https://%s/%s/%s/branches/yours\n", c.host, c.username, c.repo)
 	}
 
 	if localOnlyBranches := branches.filter(func(b branch) bool {
@@ -216,25 +475,538 @@ func runSync(ctx context.Context, c config) error {
 	return nil
 }
 
-type commit struct {
-	sha        string
-	commitDate time.Time
+// commitSupersededByPR reports whether commit is the PR's head commit, or
+// was made before it -- the signal runSync and runWatch use to decide it's
+// safe to delete a branch whose PR has closed.
+func commitSupersededByPR(commit *hosting.Commit, b *branch) bool {
+	return commit.SHA == b.pr.SHA || commit.CommitDate.Before(b.pr.CommitDate)
+}
+
+// refreshMergedStatus returns b's PR Merged flag and MergeCommit, paying for
+// a GetPR round trip only when commitSupersededByPR hasn't already cleared
+// both the local and remote copies of the branch -- the PR list/search
+// endpoints that built b.pr don't reliably report Merged or MergeCommit for
+// squash-merged PRs, so the cheap SHA/date check is what's usually good
+// enough on its own. runSync and pruneMergedBranches share this so the two
+// deletion paths never disagree about whether a PR counts as merged.
+func refreshMergedStatus(ctx context.Context, c config, b *branch) (merged bool, mergeCommit string, err error) {
+	remoteSuperseded := b.remote == nil || commitSupersededByPR(b.remote, b)
+	localSuperseded := b.local == nil || commitSupersededByPR(b.local, b)
+	if remoteSuperseded && localSuperseded {
+		return b.pr.Merged, b.pr.MergeCommit, nil
+	}
+	full, err := c.provider.GetPR(ctx, c.upstreamUsername, c.repo, b.pr.Number)
+	if err != nil {
+		return b.pr.Merged, b.pr.MergeCommit, err
+	}
+	return full.Merged, full.MergeCommit, nil
+}
+
+// branchQualifiesForDeletion reports whether a branch is safe to delete now
+// that its PR is no longer open, and why. commitSupersededByPR's SHA/date
+// check is the fast path. Past that, gitRef has commits newer than the PR's
+// head, and two things could be true: either they were never part of the PR
+// (unsafe to delete -- for example, a developer reusing a branch after its
+// PR merged), or the PR was squash- or rebase-merged, so gitRef's real
+// content already landed upstream under a different SHA and date. `git
+// cherry` distinguishes the two cases by patch-id, so it's required to
+// corroborate "PR #N merged" before that signal is trusted. Only when
+// upstreamDefaultRef couldn't be determined at all -- so there's nothing to
+// corroborate against -- does the PR's Merged flag get trusted on its own,
+// as a last resort.
+func branchQualifiesForDeletion(
+	commit *hosting.Commit, b *branch, upstreamDefaultRef, gitRef string, merged bool, mergeCommit string,
+) (bool, string) {
+	if commitSupersededByPR(commit, b) {
+		return true, fmt.Sprintf("PR #%d is closed and this commit is no newer than its head", b.pr.Number)
+	}
+	if upstreamDefaultRef == "" {
+		if merged {
+			reason := fmt.Sprintf("PR #%d is merged", b.pr.Number)
+			if mergeCommit != "" {
+				reason += fmt.Sprintf(" (as %.12s)", mergeCommit)
+			}
+			return true, reason
+		}
+		return false, ""
+	}
+	clean, err := branchMergedViaCherryPick(upstreamDefaultRef, gitRef)
+	if err != nil {
+		fmt.Printf("Warning: couldn't compare %s against %s: %s\n", gitRef, upstreamDefaultRef, err)
+		return false, ""
+	}
+	if !clean {
+		return false, ""
+	}
+	reason := fmt.Sprintf("every commit on %s already has an equivalent on %s", gitRef, upstreamDefaultRef)
+	if merged {
+		reason = fmt.Sprintf("PR #%d is merged and %s", b.pr.Number, reason)
+	}
+	return true, reason
+}
+
+// branchMergedViaCherryPick reports whether every commit reachable from
+// gitRef but not from upstreamDefaultRef is, by patch-id, equivalent to a
+// commit that's already on upstreamDefaultRef. `git cherry` prefixes such
+// commits with "-"; anything still prefixed "+" hasn't landed. This is the
+// signal that survives a squash or rebase merge, where the branch's own SHA
+// never appears in upstream's history but the patches it introduced do.
+func branchMergedViaCherryPick(upstreamDefaultRef, gitRef string) (bool, error) {
+	out, err := capture("git", "cherry", "-v", upstreamDefaultRef, gitRef)
+	if err != nil {
+		return false, err
+	}
+	for _, line := range strings.Split(out, "\n") {
+		if strings.HasPrefix(line, "+") {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// defaultBranchRef returns the remote-tracking ref (for example
+// "upstream/main") that remote's HEAD points to, for use as the base of the
+// patch-id comparison in branchMergedViaCherryPick. It relies on
+// `git remote set-head`, which `git clone` runs automatically but a manually
+// added remote may need run explicitly.
+func defaultBranchRef(remote string) (string, error) {
+	ref, err := capture("git", "symbolic-ref", "--quiet", "--short", "refs/remotes/"+remote+"/HEAD")
+	if err != nil {
+		return "", errors.Wrapf(err, "determining default branch for remote %q (try `git remote set-head %s --auto`)", remote, remote)
+	}
+	return ref, nil
+}
+
+func runWatch(ctx context.Context, c config) error {
+	var interval time.Duration
+	var autoPrune bool
+	flagSet := flag.NewFlagSet("watch", flag.ContinueOnError)
+	flagSet.DurationVar(&interval, "interval", 60*time.Second, "how often to poll for branch and PR changes")
+	flagSet.BoolVar(&autoPrune, "auto-prune", false, "automatically delete branches whose PR has merged or closed")
+	if err := flagSet.Parse(os.Args[2:]); err != nil {
+		return err
+	} else if flagSet.NArg() != 0 {
+		return errors.New(usage)
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+	defer stop()
+
+	statePath := filepath.Join(c.gitDir, "prmaster", "state.json")
+	prev, err := loadWatchState(statePath)
+	if err != nil {
+		return errors.Wrap(err, "loading watch state")
+	}
+
+	fmt.Printf("Watching %s/%s every %s. Press Ctrl-C to stop.\n", c.upstreamUsername, c.repo, interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		bs, err := loadBranchesWithBackoff(ctx, c)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		curr := snapshotBranches(bs)
+		for _, t := range diffSnapshots(prev, curr) {
+			fmt.Println(t)
+		}
+		if err := saveWatchState(statePath, curr); err != nil {
+			return errors.Wrap(err, "saving watch state")
+		}
+		prev = curr
+
+		if autoPrune {
+			if err := pruneMergedBranches(ctx, c, bs); err != nil {
+				return err
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			fmt.Println("Stopping.")
+			return nil
+		case <-ticker.C:
+		}
+	}
 }
 
-func newCommit(repoCommit *github.RepositoryCommit) *commit {
-	return &commit{
-		sha:        repoCommit.GetSHA(),
-		commitDate: repoCommit.GetCommit().GetCommitter().GetDate(),
+// loadBranchesWithBackoff wraps loadBranches with exponential backoff on
+// GitHub's rate-limit error, honoring the Reset time it reports rather than
+// guessing at a retry interval. Other providers don't yet report rate limits
+// in a way prmaster recognizes, so their errors are returned unchanged.
+func loadBranchesWithBackoff(ctx context.Context, c config) (branches, error) {
+	backoff := time.Second
+	for {
+		bs, err := loadBranches(ctx, c)
+		if err == nil {
+			return bs, nil
+		}
+		rlErr, ok := errors.Cause(err).(*ghapi.RateLimitError)
+		if !ok {
+			return nil, err
+		}
+		wait := time.Until(rlErr.Rate.Reset.Time)
+		if wait < backoff {
+			wait = backoff
+		}
+		fmt.Printf("Rate limited; waiting %s before retrying...\n", wait.Round(time.Second))
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+		backoff *= 2
+	}
+}
+
+// pruneMergedBranches deletes local and remote branches whose PR has closed
+// or merged, using the same branchQualifiesForDeletion signals -- SHA/date,
+// GetPR's Merged flag, and git-cherry patch-id equivalence -- as runSync, so
+// that a branch auto-pruning during `watch` leaves behind is never one that
+// `sync` would have deleted outright. It never touches the currently
+// checked-out branch or anything that looks like a release branch.
+func pruneMergedBranches(ctx context.Context, c config, bs branches) error {
+	currentBranch, err := capture("git", "symbolic-ref", "--quiet", "--short", "HEAD")
+	if err != nil {
+		return err
+	}
+
+	upstreamDefaultRef, err := defaultBranchRef(c.upstreamRemote)
+	if err != nil {
+		fmt.Printf("Warning: %s; skipping patch-id based branch detection.\n", err)
+	}
+
+	var localDeletes, remoteDeletes []*branch
+	var localReasons, remoteReasons []string
+	for i := range bs {
+		b := &bs[i]
+		if b.isRelease() || b.name == currentBranch || b.pr.Commit == nil || b.pr.State == "open" {
+			continue
+		}
+
+		merged, mergeCommit, err := refreshMergedStatus(ctx, c, b)
+		if err != nil {
+			fmt.Printf("Warning: couldn't refresh PR #%d: %s\n", b.pr.Number, err)
+		}
+
+		if b.remote != nil {
+			if ok, reason := branchQualifiesForDeletion(
+				b.remote, b, upstreamDefaultRef, c.remote+"/"+b.name, merged, mergeCommit,
+			); ok {
+				remoteDeletes = append(remoteDeletes, b)
+				remoteReasons = append(remoteReasons, reason)
+			}
+		}
+		if b.local != nil {
+			if ok, reason := branchQualifiesForDeletion(
+				b.local, b, upstreamDefaultRef, b.name, merged, mergeCommit,
+			); ok {
+				localDeletes = append(localDeletes, b)
+				localReasons = append(localReasons, reason)
+			}
+		}
+	}
+
+	if len(localDeletes) > 0 {
+		args := []string{"git", "branch", "-qD"}
+		for i, b := range localDeletes {
+			args = append(args, b.name)
+			fmt.Printf("Auto-pruning local %s. %s.\n", color.Bold(b.name), localReasons[i])
+		}
+		if err := spawn(args...); err != nil {
+			return errors.Wrap(err, "auto-pruning local branches")
+		}
+	}
+	if len(remoteDeletes) > 0 {
+		args := []string{"git", "push", "-qd", c.remote}
+		for i, b := range remoteDeletes {
+			args = append(args, b.name)
+			fmt.Printf("Auto-pruning remote %s. %s.\n", color.Bold(b.name), remoteReasons[i])
+		}
+		if err := spawn(args...); err != nil {
+			return errors.Wrap(err, "auto-pruning remote branches")
+		}
+	}
+	return nil
+}
+
+// branchSnapshot is the subset of a branch's state that runWatch persists
+// between ticks, so it can diff successive polls without refetching
+// everything from the hosting provider.
+type branchSnapshot struct {
+	Name      string `json:"name"`
+	LocalSHA  string `json:"localSHA,omitempty"`
+	RemoteSHA string `json:"remoteSHA,omitempty"`
+	PRSHA     string `json:"prSHA,omitempty"`
+	PRState   string `json:"prState,omitempty"`
+	PRNumber  int    `json:"prNumber,omitempty"`
+	PRMerged  bool   `json:"prMerged,omitempty"`
+}
+
+// watchState is the on-disk format of $GIT_DIR/prmaster/state.json.
+type watchState struct {
+	Branches map[string]branchSnapshot `json:"branches"`
+}
+
+func snapshotBranches(bs branches) map[string]branchSnapshot {
+	out := make(map[string]branchSnapshot, len(bs))
+	for _, b := range bs {
+		s := branchSnapshot{Name: b.name}
+		if b.local != nil {
+			s.LocalSHA = b.local.SHA
+		}
+		if b.remote != nil {
+			s.RemoteSHA = b.remote.SHA
+		}
+		if b.pr.PR != nil {
+			s.PRSHA = b.pr.SHA
+			s.PRState = b.pr.State
+			s.PRNumber = b.pr.Number
+			s.PRMerged = b.pr.Merged
+		}
+		out[b.name] = s
+	}
+	return out
+}
+
+// diffSnapshots compares two successive branch snapshots and describes the
+// transitions between them: PRs opening, merging, or closing; new commits
+// landing on a tracked remote branch; and branches losing their PR
+// association entirely.
+func diffSnapshots(prev, curr map[string]branchSnapshot) []string {
+	var transitions []string
+	for name, c := range curr {
+		p, existed := prev[name]
+		if !existed {
+			if c.PRNumber != 0 {
+				transitions = append(transitions, fmt.Sprintf("%s: PR #%d opened", name, c.PRNumber))
+			}
+			continue
+		}
+		if p.PRNumber == 0 && c.PRNumber != 0 {
+			transitions = append(transitions, fmt.Sprintf("%s: PR #%d opened", name, c.PRNumber))
+		}
+		if p.PRState == "open" && c.PRState == "closed" {
+			if c.PRMerged {
+				transitions = append(transitions, fmt.Sprintf("%s: PR #%d merged", name, c.PRNumber))
+			} else {
+				transitions = append(transitions, fmt.Sprintf("%s: PR #%d closed", name, c.PRNumber))
+			}
+		}
+		if c.RemoteSHA != "" && p.RemoteSHA != "" && c.RemoteSHA != p.RemoteSHA {
+			transitions = append(transitions, fmt.Sprintf("%s: new commit pushed to remote", name))
+		}
+		if p.PRNumber != 0 && c.PRNumber == 0 && c.RemoteSHA != "" {
+			transitions = append(transitions, fmt.Sprintf("%s: orphaned, no longer associated with any PR", name))
+		}
+	}
+	return transitions
+}
+
+func loadWatchState(path string) (map[string]branchSnapshot, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return map[string]branchSnapshot{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var state watchState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+	if state.Branches == nil {
+		state.Branches = map[string]branchSnapshot{}
+	}
+	return state.Branches, nil
+}
+
+func saveWatchState(path string, snapshot map[string]branchSnapshot) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(watchState{Branches: snapshot}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// githubClientID identifies prmaster to GitHub's OAuth device flow. It's not
+// a secret -- the device flow is designed so that public clients can embed
+// their client ID directly in distributed binaries -- so it's safe to
+// hardcode here rather than plumb through configuration.
+const githubClientID = "Iv1.8a61f9b3a7aba766"
+
+const (
+	deviceCodeURL  = "https://github.com/login/device/code"
+	accessTokenURL = "https://github.com/login/oauth/access_token"
+)
+
+func runLogin(ctx context.Context) error {
+	var pat bool
+	flagSet := flag.NewFlagSet("login", flag.ContinueOnError)
+	flagSet.BoolVar(&pat, "pat", false, "authenticate with a personal access token instead of the OAuth device flow")
+	if err := flagSet.Parse(os.Args[2:]); err != nil {
+		return err
+	} else if flagSet.NArg() != 0 {
+		return errors.New(usage)
+	}
+
+	var token string
+	var err error
+	if pat {
+		token, err = loginWithPAT(ctx)
+	} else {
+		token, err = loginWithDeviceFlow(ctx)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := spawn("git", "config", "--global", "prmaster.githubToken", token); err != nil {
+		return errors.Wrap(err, "saving token")
+	}
+	fmt.Println("Logged in. prmaster.githubToken has been set in your global Git config.")
+	return nil
+}
+
+type deviceCodeResponse struct {
+	DeviceCode      string `json:"device_code"`
+	UserCode        string `json:"user_code"`
+	VerificationURI string `json:"verification_uri"`
+	ExpiresIn       int    `json:"expires_in"`
+	Interval        int    `json:"interval"`
+}
+
+type accessTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	Error       string `json:"error"`
+}
+
+// loginWithDeviceFlow performs GitHub's OAuth device authorization flow:
+// https://docs.github.com/en/apps/oauth-apps/building-oauth-apps/authorizing-oauth-apps#device-flow.
+func loginWithDeviceFlow(ctx context.Context) (string, error) {
+	var dc deviceCodeResponse
+	dcForm := url.Values{"client_id": {githubClientID}, "scope": {"repo"}}
+	if err := postForm(ctx, deviceCodeURL, dcForm, &dc); err != nil {
+		return "", errors.Wrap(err, "requesting device code")
+	}
+
+	fmt.Printf("First, copy your one-time code: %s\n", color.Bold(dc.UserCode))
+	fmt.Printf("Then open %s in your browser to authorize prmaster.\n", dc.VerificationURI)
+
+	interval := time.Duration(dc.Interval) * time.Second
+	if interval == 0 {
+		interval = 5 * time.Second
+	}
+	deadline := time.Now().Add(time.Duration(dc.ExpiresIn) * time.Second)
+
+	atForm := url.Values{
+		"client_id":   {githubClientID},
+		"device_code": {dc.DeviceCode},
+		"grant_type":  {"urn:ietf:params:oauth:grant-type:device_code"},
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(interval):
+		}
+		if time.Now().After(deadline) {
+			return "", errors.New("device code expired before authorization completed")
+		}
+
+		var at accessTokenResponse
+		if err := postForm(ctx, accessTokenURL, atForm, &at); err != nil {
+			return "", errors.Wrap(err, "polling for access token")
+		}
+		switch at.Error {
+		case "":
+			return at.AccessToken, nil
+		case "authorization_pending":
+			// Not yet authorized. Keep polling at the same interval.
+		case "slow_down":
+			interval += 5 * time.Second
+		case "expired_token":
+			return "", errors.New("device code expired before authorization completed")
+		case "access_denied":
+			return "", errors.New("authorization was denied")
+		default:
+			return "", errors.Errorf("unexpected error polling for access token: %s", at.Error)
+		}
+	}
+}
+
+func postForm(ctx context.Context, rawURL string, form url.Values, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", rawURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+// loginWithPAT validates a hand-crafted personal access token, retrying
+// with the X-GitHub-OTP header -- the same way hub and gh handle two-factor
+// prompts -- if GitHub reports that an OTP is required.
+func loginWithPAT(ctx context.Context) (string, error) {
+	fmt.Println("Create a personal access token at https://github.com/settings/tokens/new?scopes=repo")
+	fmt.Print("Paste your personal access token: ")
+	reader := bufio.NewReader(os.Stdin)
+	token, err := reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	token = strings.TrimSpace(token)
+
+	httpClient := oauth2.NewClient(ctx, oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token}))
+	client := ghapi.NewClient(httpClient)
+
+	var otp string
+	for {
+		req, err := client.NewRequest("GET", "user", nil)
+		if err != nil {
+			return "", err
+		}
+		if otp != "" {
+			req.Header.Set("X-GitHub-OTP", otp)
+		}
+		var user ghapi.User
+		res, err := client.Do(ctx, req, &user)
+		if err == nil {
+			return token, nil
+		}
+		if res == nil || res.StatusCode != http.StatusUnauthorized || res.Header.Get("X-GitHub-OTP") == "" {
+			return "", errors.Wrap(err, "validating personal access token")
+		}
+
+		fmt.Print("Two-factor authentication code: ")
+		otp, err = reader.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		otp = strings.TrimSpace(otp)
 	}
 }
 
 type branch struct {
 	name   string
-	local  *commit
-	remote *commit
+	local  *hosting.Commit
+	remote *hosting.Commit
 	pr     struct {
-		*commit
-		*github.PullRequest
+		*hosting.Commit
+		*hosting.PR
 	}
 }
 
@@ -247,21 +1019,15 @@ func loadBranches(ctx context.Context, c config) (branches, error) {
 	}
 
 	// Collect remote branches.
-	for page := 1; page != 0; {
-		ghBranches, res, err := c.ghClient.Repositories.ListBranches(
-			ctx, username, c.repo, &github.ListOptions{PerPage: 100, Page: page})
-		if err != nil {
-			return nil, err
-		}
-		for _, b := range ghBranches {
-			if strings.HasPrefix(b.GetName(), c.branchPrefix) {
-				branches = append(branches, branch{
-					name:   b.GetName(),
-					remote: newCommit(b.GetCommit()),
-				})
-			}
+	hostBranches, err := c.provider.ListBranches(ctx, username, c.repo)
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range hostBranches {
+		if strings.HasPrefix(b.Name, c.branchPrefix) {
+			commit := b.Commit
+			branches = append(branches, branch{name: b.Name, remote: &commit})
 		}
-		page = res.NextPage
 	}
 
 	// Collect local branches.
@@ -281,7 +1047,7 @@ outer:
 		if err != nil {
 			return nil, err
 		}
-		commit := &commit{sha: sha, commitDate: date}
+		commit := &hosting.Commit{SHA: sha, CommitDate: date}
 		for i := range branches {
 			if branches[i].name == name {
 				branches[i].local = commit
@@ -312,25 +1078,21 @@ outer:
 	// From https://docs.github.com/en/rest/guides/best-practices-for-integrators#dealing-with-secondary-rate-limits:
 	// > Make requests for a single user or client ID serially. Do not make
 	// > requests for a single user or client ID concurrently.
+	//
+	// GitLab and Gitea don't document the same restriction, but there's no
+	// harm in being just as polite to them.
 	sem := make(chan struct{}, 32)
 	for i := range branches {
 		i := i
 		g.Go(func() error {
 			sem <- struct{}{}
 			defer func() { <-sem }()
-			prOpts := &github.PullRequestListOptions{
-				State: "all",
-				Head:  fmt.Sprintf("%s:%s", username, branches[i].name),
-			}
-			prs, _, err := c.ghClient.PullRequests.List(ctx, c.upstreamUsername, c.repo, prOpts)
+			pr, err := c.provider.FindPRForBranch(ctx, c.upstreamUsername, c.repo, username, branches[i].name)
 			if err != nil {
 				return err
 			}
-			if len(prs) != 0 {
-				// PRs are sorted so that the most recent PR is first.
-				pr := prs[0]
-				commits, _, err := c.ghClient.PullRequests.ListCommits(ctx, c.upstreamUsername,
-					c.repo, pr.GetNumber(), nil /* listOptions */)
+			if pr != nil {
+				commits, err := c.provider.ListPRCommits(ctx, c.upstreamUsername, c.repo, pr.Number)
 				if err != nil {
 					return err
 				}
@@ -338,8 +1100,9 @@ outer:
 					// TODO: Is this an error?
 					return nil
 				}
-				branches[i].pr.PullRequest = pr
-				branches[i].pr.commit = newCommit(commits[len(commits)-1])
+				branches[i].pr.PR = pr
+				lastCommit := commits[len(commits)-1]
+				branches[i].pr.Commit = &lastCommit
 			}
 			bar.Increment()
 			return nil
@@ -372,9 +1135,11 @@ func (bs branches) filter(fn func(branch) bool) branches {
 }
 
 type config struct {
-	ghClient         *github.Client
+	provider         hosting.Provider
+	host             string
 	upstreamUsername string
 	repo             string
+	upstreamRemote   string
 	remote           string
 	username         string
 	personal         bool
@@ -384,42 +1149,78 @@ type config struct {
 
 var errNoRemote = errors.New("remote does not exist")
 
-func tryUpstream(remote string) (upstreamUsername, repo string, err error) {
+// remoteURLMatcher parses the host, owner, and repo out of a Git remote URL,
+// in either its SSH (git@host:owner/repo.git) or HTTP(S)
+// (https://host/owner/repo.git) form. It isn't tied to any particular forge,
+// so the same logic guesses the upstream host for GitHub, GitLab, Gitea, or
+// any other forge that uses the conventional owner/repo URL layout. The
+// owner group is greedy so it swallows GitLab's nested group/subgroup
+// namespaces too, leaving only the final path segment as the repo.
+var remoteURLMatcher = regexp.MustCompile(
+	`^(?:https?://|ssh://)?(?:[^@/\s]+@)?([[:alnum:].\-]+)(?::\d+)?[:/](.+)/([[:alnum:]\-_.]+?)(?:\.git)?$`)
+
+// parseRemoteURL extracts the host, owner, and repo from a Git remote URL.
+func parseRemoteURL(remoteURL string) (host, owner, repo string, err error) {
+	m := remoteURLMatcher.FindStringSubmatch(remoteURL)
+	if len(m) != 4 {
+		return "", "", "", errors.Errorf("unable to parse remote URL %q", remoteURL)
+	}
+	return m[1], m[2], m[3], nil
+}
+
+func tryUpstream(remote string) (host, upstreamUsername, repo string, err error) {
 	upstreamURL, _ := capture("git", "config", "--get", fmt.Sprintf("remote.%s.url", remote))
 	if upstreamURL == "" {
-		return "", "", errNoRemote
+		return "", "", "", errNoRemote
 	}
-	m := regexp.MustCompile(`github.com(:|/)([[:alnum:]\-]+)/([[:alnum:]\-]+)`).FindStringSubmatch(upstreamURL)
-	if len(m) != 4 {
-		return "", "", errors.Errorf("unable to guess upstream GitHub information from remote %q (%s)",
-			remote, upstreamURL)
+	host, upstreamUsername, repo, err = parseRemoteURL(upstreamURL)
+	if err != nil {
+		return "", "", "", errors.Wrapf(err, "guessing upstream information from remote %q", remote)
+	}
+	return host, upstreamUsername, repo, nil
+}
+
+// detectProvider guesses which hosting.Provider implementation to use from
+// the upstream remote's host, so that prmaster.provider only needs to be set
+// explicitly for self-hosted instances whose hostname doesn't say what they
+// are.
+func detectProvider(host string) string {
+	switch {
+	case strings.Contains(host, "github"):
+		return "github"
+	case strings.Contains(host, "gitlab"):
+		return "gitlab"
+	case strings.Contains(host, "gitea"):
+		return "gitea"
+	default:
+		return ""
 	}
-	return m[2], m[3], nil
 }
 
 func loadConfig(ctx context.Context) (config, error) {
 	var c config
 
-	// Determine upstream username and repo.
+	// Determine upstream host, username, and repo.
 	var err error
 	upstreamRemote := "upstream"
-	c.upstreamUsername, c.repo, err = tryUpstream("upstream")
+	c.host, c.upstreamUsername, c.repo, err = tryUpstream("upstream")
 	if err != nil {
 		if err != errNoRemote {
 			return c, err
 		}
 		upstreamRemote = "origin"
-		c.upstreamUsername, c.repo, err = tryUpstream("origin")
+		c.host, c.upstreamUsername, c.repo, err = tryUpstream("origin")
 		if err == errNoRemote {
 			return c, hintedErr{
-				error: errors.New("unable to guess upstream GitHub information"),
+				error: errors.New("unable to guess upstream hosting information"),
 				hint: `ensure you have a remote named either "upstream" or "origin" that is
-configured with a GitHub URL`,
+configured with a GitHub, GitLab, or Gitea URL`,
 			}
 		} else if err != nil {
 			return c, err
 		}
 	}
+	c.upstreamRemote = upstreamRemote
 
 	// Determine remote.
 	c.remote, _ = capture("git", "config", "--get", "prmaster.personalRemote")
@@ -457,11 +1258,10 @@ The old configuration setting, cockroach.remote, is no longer checked.
 		if err != nil {
 			return c, errors.Wrapf(err, "determining URL for remote %q", c.remote)
 		}
-		m := regexp.MustCompile(`github.com(:|/)([[:alnum:]\-]+)`).FindStringSubmatch(remoteURL)
-		if len(m) != 3 {
-			return c, errors.Errorf("unable to guess GitHub username from remote %q (%s)",
-				c.remote, remoteURL)
-		} else if m[2] == c.upstreamUsername {
+		_, owner, _, err := parseRemoteURL(remoteURL)
+		if err != nil {
+			return c, errors.Wrapf(err, "guessing username from remote %q", c.remote)
+		} else if owner == c.upstreamUsername {
 			return c, errors.Errorf("refusing to use unforked remote %q (%s)",
 				c.remote, remoteURL)
 		}
@@ -470,23 +1270,36 @@ The old configuration setting, cockroach.remote, is no longer checked.
 	// Determine branch prefix, if any.
 	c.branchPrefix, _ = capture("git", "config", "--get", "prmaster.branchPrefix")
 
-	// Build GitHub client.
-	var ghAuthClient *http.Client
-	ghToken, _ := capture("git", "config", "--get", "prmaster.githubToken")
-	if ghToken == "" {
-		ghToken, _ = capture("git", "config", "--get", "cockroach.githubToken")
+	// Build the hosting provider.
+	providerName, _ := capture("git", "config", "--get", "prmaster.provider")
+	if providerName == "" {
+		providerName = detectProvider(c.host)
 	}
-	if ghToken != "" {
-		ghAuthClient = oauth2.NewClient(ctx, oauth2.StaticTokenSource(
-			&oauth2.Token{AccessToken: ghToken}))
+	baseURL, _ := capture("git", "config", "--get", "prmaster.apiBaseURL")
+	switch providerName {
+	case "", "github":
+		ghToken, _ := capture("git", "config", "--get", "prmaster.githubToken")
+		if ghToken == "" {
+			ghToken, _ = capture("git", "config", "--get", "cockroach.githubToken")
+		}
+		c.provider, err = githubhosting.New(ctx, ghToken, baseURL)
+	case "gitlab":
+		glToken, _ := capture("git", "config", "--get", "prmaster.gitlabToken")
+		c.provider, err = gitlabhosting.New(ctx, glToken, baseURL)
+	case "gitea":
+		giToken, _ := capture("git", "config", "--get", "prmaster.giteaToken")
+		c.provider, err = giteahosting.New(ctx, giToken, baseURL)
+	default:
+		return c, errors.Errorf("unknown prmaster.provider %q (want github, gitlab, or gitea)", providerName)
+	}
+	if err != nil {
+		return c, errors.Wrap(err, "building hosting provider")
 	}
-	c.ghClient = github.NewClient(ghAuthClient)
 
-	user, _, err := c.ghClient.Users.Get(ctx, "")
+	c.username, err = c.provider.CurrentUser(ctx)
 	if err != nil {
-		return c, errors.Wrap(err, "looking up GitHub username")
+		return c, errors.Wrap(err, "looking up username")
 	}
-	c.username = *user.Login
 
 	// Determine Git directory.
 	c.gitDir, err = capture("git", "rev-parse", "--git-dir")
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	ghapi "github.com/google/go-github/github"
+)
+
+func review(user, state string) *ghapi.PullRequestReview {
+	return &ghapi.PullRequestReview{
+		User:  &ghapi.User{Login: ghapi.String(user)},
+		State: ghapi.String(state),
+	}
+}
+
+func TestCollapseReviews(t *testing.T) {
+	tests := []struct {
+		name    string
+		reviews []*ghapi.PullRequestReview
+		want    reviewState
+	}{
+		{
+			name:    "no reviews",
+			reviews: nil,
+			want:    reviewNone,
+		},
+		{
+			name:    "single approval",
+			reviews: []*ghapi.PullRequestReview{review("alice", "APPROVED")},
+			want:    reviewApproved,
+		},
+		{
+			name:    "single changes requested",
+			reviews: []*ghapi.PullRequestReview{review("alice", "CHANGES_REQUESTED")},
+			want:    reviewChangesRequested,
+		},
+		{
+			name:    "approved then changes requested by another reviewer",
+			reviews: []*ghapi.PullRequestReview{review("alice", "APPROVED"), review("bob", "CHANGES_REQUESTED")},
+			want:    reviewChangesRequested,
+		},
+		{
+			name:    "comment after approval doesn't clear it",
+			reviews: []*ghapi.PullRequestReview{review("alice", "APPROVED"), review("alice", "COMMENTED")},
+			want:    reviewApproved,
+		},
+		{
+			name:    "standalone comment isn't a verdict",
+			reviews: []*ghapi.PullRequestReview{review("alice", "COMMENTED")},
+			want:    reviewPending,
+		},
+		{
+			name:    "dismissed approval goes back to pending",
+			reviews: []*ghapi.PullRequestReview{review("alice", "APPROVED"), review("alice", "DISMISSED")},
+			want:    reviewPending,
+		},
+		{
+			name: "dismissed approval doesn't mask another reviewer's changes requested",
+			reviews: []*ghapi.PullRequestReview{
+				review("alice", "APPROVED"),
+				review("alice", "DISMISSED"),
+				review("bob", "CHANGES_REQUESTED"),
+			},
+			want: reviewChangesRequested,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := collapseReviews(tt.reviews); got != tt.want {
+				t.Errorf("collapseReviews() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffSnapshots(t *testing.T) {
+	tests := []struct {
+		name string
+		prev map[string]branchSnapshot
+		curr map[string]branchSnapshot
+		want []string
+	}{
+		{
+			name: "new branch with no PR produces no transition",
+			prev: map[string]branchSnapshot{},
+			curr: map[string]branchSnapshot{"feature": {Name: "feature"}},
+			want: nil,
+		},
+		{
+			name: "PR opened on a brand new branch",
+			prev: map[string]branchSnapshot{},
+			curr: map[string]branchSnapshot{"feature": {Name: "feature", PRNumber: 7}},
+			want: []string{"feature: PR #7 opened"},
+		},
+		{
+			name: "PR opened on a previously tracked branch",
+			prev: map[string]branchSnapshot{"feature": {Name: "feature"}},
+			curr: map[string]branchSnapshot{"feature": {Name: "feature", PRNumber: 7}},
+			want: []string{"feature: PR #7 opened"},
+		},
+		{
+			name: "PR merged",
+			prev: map[string]branchSnapshot{"feature": {Name: "feature", PRNumber: 7, PRState: "open"}},
+			curr: map[string]branchSnapshot{"feature": {Name: "feature", PRNumber: 7, PRState: "closed", PRMerged: true}},
+			want: []string{"feature: PR #7 merged"},
+		},
+		{
+			name: "PR closed without merging",
+			prev: map[string]branchSnapshot{"feature": {Name: "feature", PRNumber: 7, PRState: "open"}},
+			curr: map[string]branchSnapshot{"feature": {Name: "feature", PRNumber: 7, PRState: "closed"}},
+			want: []string{"feature: PR #7 closed"},
+		},
+		{
+			name: "new commit pushed to remote",
+			prev: map[string]branchSnapshot{"feature": {Name: "feature", RemoteSHA: "aaa"}},
+			curr: map[string]branchSnapshot{"feature": {Name: "feature", RemoteSHA: "bbb"}},
+			want: []string{"feature: new commit pushed to remote"},
+		},
+		{
+			name: "branch orphaned from its PR",
+			prev: map[string]branchSnapshot{"feature": {Name: "feature", PRNumber: 7, RemoteSHA: "aaa"}},
+			curr: map[string]branchSnapshot{"feature": {Name: "feature", RemoteSHA: "aaa"}},
+			want: []string{"feature: orphaned, no longer associated with any PR"},
+		},
+		{
+			name: "no change produces no transition",
+			prev: map[string]branchSnapshot{"feature": {Name: "feature", PRNumber: 7, PRState: "open", RemoteSHA: "aaa"}},
+			curr: map[string]branchSnapshot{"feature": {Name: "feature", PRNumber: 7, PRState: "open", RemoteSHA: "aaa"}},
+			want: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := diffSnapshots(tt.prev, tt.curr)
+			sort.Strings(got)
+			sort.Strings(tt.want)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("diffSnapshots() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}